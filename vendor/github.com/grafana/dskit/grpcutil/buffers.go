@@ -0,0 +1,78 @@
+package grpcutil
+
+import (
+	"bytes"
+	"sync"
+)
+
+// RequestBuffers pools the buffers used while parsing a proto request: the
+// *bytes.Buffer used to read the (possibly compressed) body off the wire,
+// and the []byte used as the destination of decompression. Reusing both
+// across requests avoids an allocation per call on the ingest hot path.
+//
+// The zero value is not usable; construct one with NewRequestBuffers.
+type RequestBuffers struct {
+	buffers sync.Pool
+	dsts    sync.Pool
+}
+
+// NewRequestBuffers creates an empty RequestBuffers pool.
+func NewRequestBuffers() *RequestBuffers {
+	return &RequestBuffers{
+		buffers: sync.Pool{
+			New: func() interface{} { return &bytes.Buffer{} },
+		},
+		dsts: sync.Pool{
+			New: func() interface{} { b := make([]byte, 0, 1024); return &b },
+		},
+	}
+}
+
+// Get returns a *bytes.Buffer from the pool, reset and ready to read into.
+func (r *RequestBuffers) Get() *bytes.Buffer {
+	buf := r.buffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// Put returns buf to the pool. Callers must not use buf after calling Put.
+func (r *RequestBuffers) Put(buf *bytes.Buffer) {
+	r.buffers.Put(buf)
+}
+
+// getDst returns a []byte of length size from the pool, reallocating if the
+// pooled slice's capacity is too small. The length (not just capacity) must
+// match size: snappy.Decode and s2.Decode size their destination off
+// len(dst), so a dst[:0] slice — however large its backing array — makes
+// them allocate their own buffer instead of writing into the pooled one.
+// getDst returns nil when size is unknown (<=0), letting those codecs fall
+// back to allocating as they would without a pool.
+func (r *RequestBuffers) getDst(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	dst := *r.dsts.Get().(*[]byte)
+	if cap(dst) < size {
+		return make([]byte, size)
+	}
+	return dst[:size]
+}
+
+// putDst returns dst to the pool. Callers must not use dst after calling
+// putDst, which is handled by Release.
+func (r *RequestBuffers) putDst(dst []byte) {
+	r.dsts.Put(&dst)
+}
+
+// Release returns buf and dst to the pool, skipping either one that is nil.
+// ParseProtoReaderWithBuffers calls it itself for the buffers it draws, so
+// its callers have nothing left to release. Callers must not use buf or dst
+// after calling Release.
+func (r *RequestBuffers) Release(buf *bytes.Buffer, dst []byte) {
+	if buf != nil {
+		r.Put(buf)
+	}
+	if dst != nil {
+		r.putDst(dst)
+	}
+}
@@ -0,0 +1,94 @@
+package grpcutil
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestRequestBuffersGetDstSizing(t *testing.T) {
+	buffers := NewRequestBuffers()
+
+	if dst := buffers.getDst(0); dst != nil {
+		t.Errorf("getDst(0) = %v, want nil (unknown size should not pool)", dst)
+	}
+
+	dst := buffers.getDst(128)
+	if len(dst) != 128 {
+		t.Fatalf("len(getDst(128)) = %d, want 128", len(dst))
+	}
+	buffers.Release(nil, dst)
+
+	// A second request asking for a smaller size should reuse the same
+	// backing array rather than reallocate.
+	dst2 := buffers.getDst(64)
+	if len(dst2) != 64 {
+		t.Fatalf("len(getDst(64)) = %d, want 64", len(dst2))
+	}
+	if &dst2[0] != &dst[0] {
+		t.Errorf("getDst(64) returned a new backing array (cap %d), want the pooled one from getDst(128) to be reused", cap(dst2))
+	}
+}
+
+func TestRequestBuffersGetPutBuffer(t *testing.T) {
+	buffers := NewRequestBuffers()
+
+	buf := buffers.Get()
+	buf.WriteString("leftover")
+	buffers.Release(buf, nil)
+
+	buf2 := buffers.Get()
+	if buf2.Len() != 0 {
+		t.Errorf("Get() after Release should return a reset buffer, got len %d", buf2.Len())
+	}
+}
+
+// TestParseProtoReaderWithBuffersReusesPooledDst is an end-to-end test of
+// the exported entry point this pooling was built for: it seeds a
+// RequestBuffers with a known destination slice, round-trips a compressed
+// message through ParseProtoReaderWithBuffers twice, and checks that same
+// backing array is what gets used (and returned) both times — not just
+// that RequestBuffers.getDst resizes correctly in isolation.
+func TestParseProtoReaderWithBuffersReusesPooledDst(t *testing.T) {
+	body := []byte("round trip through ParseProtoReaderWithBuffers using a real RequestBuffers pool")
+	compressed := snappy.Encode(nil, body)
+
+	buffers := NewRequestBuffers()
+	sentinel := make([]byte, len(body)+64)
+	sentinelPtr := &sentinel[0]
+	buffers.dsts.Put(&sentinel)
+
+	var req testMessage
+	err := ParseProtoReaderWithBuffers(context.Background(), bytes.NewReader(compressed), len(compressed), len(body)+1, &req, RawSnappy, buffers)
+	if err != nil {
+		t.Fatalf("ParseProtoReaderWithBuffers: %v", err)
+	}
+	if string(req.Data) != string(body) {
+		t.Fatalf("req.Data = %q, want %q", req.Data, body)
+	}
+
+	reused := buffers.getDst(len(body))
+	if len(reused) == 0 || &reused[0] != sentinelPtr {
+		t.Fatal("ParseProtoReaderWithBuffers did not reuse the pooled dst slice")
+	}
+
+	// Put it back and round-trip again to confirm the same backing array
+	// keeps being handed out and returned across repeated calls, not just
+	// the first.
+	buffers.dsts.Put(&reused)
+
+	var req2 testMessage
+	if err := ParseProtoReaderWithBuffers(context.Background(), bytes.NewReader(compressed), len(compressed), len(body)+1, &req2, RawSnappy, buffers); err != nil {
+		t.Fatalf("ParseProtoReaderWithBuffers (second call): %v", err)
+	}
+	if string(req2.Data) != string(body) {
+		t.Fatalf("req2.Data = %q, want %q", req2.Data, body)
+	}
+
+	again := buffers.getDst(len(body))
+	if len(again) == 0 || &again[0] != sentinelPtr {
+		t.Error("pooled dst was not reused across a second ParseProtoReaderWithBuffers call")
+	}
+}
@@ -0,0 +1,235 @@
+package grpcutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec compresses and decompresses proto payloads for a single wire
+// encoding. Built-in codecs for gzip, zstd and s2 are registered in
+// init(); callers can register additional ones (e.g. brotli) from their
+// own init() via RegisterCodec.
+type Codec interface {
+	// Name is the codec's identifier. It doubles as the Content-Encoding
+	// token negotiated over HTTP (e.g. "gzip", "zstd", "s2").
+	Name() string
+	// Encode compresses src and returns the result. dst is scratch space
+	// whose storage may be reused for the result; its contents are not
+	// preserved.
+	Encode(dst, src []byte) ([]byte, error)
+	// Decode decompresses src and returns the result. dst is scratch space
+	// whose storage may be reused for the result; its contents are not
+	// preserved. Decode must fail rather than return more than maxSize bytes.
+	Decode(dst, src []byte, maxSize int) ([]byte, error)
+}
+
+// SizedCodec is implemented by codecs that can cheaply recover the
+// decompressed size of a buffer without fully decoding it, letting callers
+// reject oversize payloads before allocating a destination buffer.
+type SizedCodec interface {
+	Codec
+	// DecompressedSize returns the decompressed size of buf, or an error if
+	// it cannot be determined.
+	DecompressedSize(buf []byte, maxSize int) (int, error)
+}
+
+// StreamingCodec is implemented by codecs that can compress directly onto
+// an io.Writer, so SerializeProtoResponse can stream a response instead of
+// allocating the whole compressed payload up front.
+type StreamingCodec interface {
+	Codec
+	// NewWriter returns a writer that compresses everything written to it
+	// onto dst. The caller must Close it to flush any trailing data.
+	NewWriter(dst io.Writer) io.WriteCloser
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+// RegisterCodec makes a Codec available for decoding requests and encoding
+// responses whose CompressionType's String() equals its Name(). No request
+// header is read here: the caller chooses the CompressionType, and a codec
+// whose Name() no CompressionType maps to is never selected. It is intended
+// to be called from init().
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// codecByName returns the registered Codec for name, if any.
+func codecByName(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+func init() {
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+	RegisterCodec(s2Codec{})
+}
+
+// gzipCodec implements Codec using the standard library's gzip package.
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst[:0])
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(dst, src []byte, maxSize int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	buf := bytes.NewBuffer(dst[:0])
+	// LimitReader+1 so an over-limit stream is detected by the length
+	// check below rather than silently truncated.
+	n, err := buf.ReadFrom(io.LimitReader(zr, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(maxSize) {
+		return nil, fmt.Errorf(messageSizeLargerErrFmt, n, maxSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// NewWriter returns a gzip.Writer wrapping dst.
+func (gzipCodec) NewWriter(dst io.Writer) io.WriteCloser {
+	return gzip.NewWriter(dst)
+}
+
+// DecompressedSize reads the trailing 4-byte ISIZE field of a gzip stream
+// (RFC 1952 section 2.3.1) to recover the decompressed size without
+// inflating the payload. ISIZE is the size modulo 2^32, so for streams
+// larger than 4GiB this is only a hint; Decode enforces maxSize directly
+// regardless.
+func (gzipCodec) DecompressedSize(buf []byte, maxSize int) (int, error) {
+	if len(buf) < 4 {
+		return 0, fmt.Errorf("gzip stream too short to contain an ISIZE trailer")
+	}
+	return int(binary.LittleEndian.Uint32(buf[len(buf)-4:])), nil
+}
+
+// zstdCodec implements Codec using github.com/klauspost/compress/zstd.
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) Encode(dst, src []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(src, dst[:0]), nil
+}
+
+func (zstdCodec) Decode(dst, src []byte, maxSize int) ([]byte, error) {
+	// Stream through a decoder rather than DecodeAll, which would
+	// materialize the whole decompressed payload before we ever get to
+	// check maxSize below. DecompressedSize is only a best-effort
+	// pre-check: a zstd frame's content-size field is optional, so an
+	// adversarial sender can omit it and defeat that check entirely.
+	// io.LimitReader is what actually bounds memory here, the same way
+	// gzipCodec.Decode bounds its own streaming decode.
+	dec, err := zstd.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	buf := bytes.NewBuffer(dst[:0])
+	n, err := buf.ReadFrom(io.LimitReader(dec, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(maxSize) {
+		return nil, fmt.Errorf(messageSizeLargerErrFmt, n, maxSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// NewWriter returns a zstd encoder wrapping dst.
+func (zstdCodec) NewWriter(dst io.Writer) io.WriteCloser {
+	enc, err := zstd.NewWriter(dst)
+	if err != nil {
+		// Only fails on invalid EncoderOptions, none of which we set.
+		panic(err)
+	}
+	return enc
+}
+
+// DecompressedSize reads the content size field from the zstd frame header,
+// when the encoder wrote one (klauspost/compress/zstd does by default). HasFCS
+// is the header field that actually says whether FrameContentSize was set;
+// a zero FrameContentSize is a legitimate value for an empty payload, so it
+// can't be used on its own to detect "unknown size".
+func (zstdCodec) DecompressedSize(buf []byte, maxSize int) (int, error) {
+	var h zstd.Header
+	if err := h.Decode(buf); err != nil {
+		return 0, err
+	}
+	if !h.HasFCS {
+		return 0, fmt.Errorf("zstd frame does not declare a content size")
+	}
+	return int(h.FrameContentSize), nil
+}
+
+// s2Codec implements Codec using github.com/klauspost/compress/s2, a
+// faster, block-compatible superset of snappy.
+type s2Codec struct{}
+
+func (s2Codec) Name() string { return "s2" }
+
+func (s2Codec) Encode(dst, src []byte) ([]byte, error) {
+	return s2.Encode(nil, src), nil
+}
+
+func (s2Codec) Decode(dst, src []byte, maxSize int) ([]byte, error) {
+	size, err := s2Codec{}.DecompressedSize(src, maxSize)
+	if err == nil && size > maxSize {
+		return nil, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
+	}
+	body, err := s2.Decode(dst, src)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf(messageSizeLargerErrFmt, len(body), maxSize)
+	}
+	return body, nil
+}
+
+// NewWriter returns an s2.Writer wrapping dst.
+func (s2Codec) NewWriter(dst io.Writer) io.WriteCloser {
+	return s2.NewWriter(dst)
+}
+
+// DecompressedSize reads s2's length prefix without decoding the block.
+func (s2Codec) DecompressedSize(buf []byte, maxSize int) (int, error) {
+	return s2.DecodedLen(buf)
+}
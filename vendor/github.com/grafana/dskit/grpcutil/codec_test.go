@@ -0,0 +1,134 @@
+package grpcutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressionTypeString(t *testing.T) {
+	for _, tc := range []struct {
+		c    CompressionType
+		want string
+	}{
+		{NoCompression, ""},
+		{RawSnappy, "snappy"},
+		{GZIP, "gzip"},
+		{Zstd, "zstd"},
+		{S2, "s2"},
+		{Brotli, "br"},
+	} {
+		if got := tc.c.String(); got != tc.want {
+			t.Errorf("CompressionType(%d).String() = %q, want %q", tc.c, got, tc.want)
+		}
+	}
+}
+
+func TestBuiltinCodecsRegistered(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd", "s2"} {
+		if _, ok := codecByName(name); !ok {
+			t.Errorf("codec %q not registered", name)
+		}
+	}
+	if _, ok := codecByName("br"); ok {
+		t.Error("brotli should not be registered by default")
+	}
+}
+
+func TestGzipCodecRoundTrip(t *testing.T) {
+	codec := gzipCodec{}
+	src := []byte("the quick brown fox jumps over the lazy dog")
+
+	encoded, err := codec.Encode(nil, src)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	size, err := codec.DecompressedSize(encoded, len(src))
+	if err != nil {
+		t.Fatalf("DecompressedSize: %v", err)
+	}
+	if size != len(src) {
+		t.Errorf("DecompressedSize = %d, want %d", size, len(src))
+	}
+
+	decoded, err := codec.Decode(nil, encoded, len(src))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if string(decoded) != string(src) {
+		t.Errorf("Decode = %q, want %q", decoded, src)
+	}
+
+	if _, err := codec.Decode(nil, encoded, len(src)-1); err == nil {
+		t.Error("Decode should reject a payload over maxSize")
+	}
+}
+
+func TestZstdCodecRejectsOversizePayload(t *testing.T) {
+	codec := zstdCodec{}
+	src := bytes.Repeat([]byte("the quick brown fox "), 256)
+
+	encoded, err := codec.Encode(nil, src)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	size, err := codec.DecompressedSize(encoded, len(src))
+	if err != nil {
+		t.Fatalf("DecompressedSize: %v", err)
+	}
+	if size != len(src) {
+		t.Errorf("DecompressedSize = %d, want %d", size, len(src))
+	}
+
+	if _, err := codec.Decode(nil, encoded, len(src)-1); err == nil {
+		t.Error("Decode should reject a payload over maxSize")
+	}
+}
+
+// TestZstdCodecDecodeBoundsMemoryWithoutContentSize covers a frame whose
+// content-size field is absent (HasFCS == false), which any client using
+// the streaming NewWriter rather than one-shot Encode will produce: the
+// encoder doesn't know the final size up front, so it can't write FCS.
+// DecompressedSize can't help here, so Decode itself must still bound
+// memory via its streaming io.LimitReader rather than trusting the frame.
+func TestZstdCodecDecodeBoundsMemoryWithoutContentSize(t *testing.T) {
+	codec := zstdCodec{}
+	src := bytes.Repeat([]byte("y"), 1<<20)
+
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	encoded := buf.Bytes()
+
+	if _, err := codec.DecompressedSize(encoded, len(src)); err == nil {
+		t.Fatal("expected DecompressedSize to report an unknown size for a streamed frame without FCS")
+	}
+
+	if _, err := codec.Decode(nil, encoded, 1024); err == nil {
+		t.Error("Decode should reject an oversize payload even when the content-size pre-check can't")
+	}
+}
+
+func TestS2CodecDecompressedSizeMatchesDecode(t *testing.T) {
+	codec := s2Codec{}
+	src := []byte("the quick brown fox jumps over the lazy dog, repeated for compressibility")
+
+	encoded, err := codec.Encode(nil, src)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	size, err := codec.DecompressedSize(encoded, len(src))
+	if err != nil {
+		t.Fatalf("DecompressedSize: %v", err)
+	}
+	if size != len(src) {
+		t.Errorf("DecompressedSize = %d, want %d", size, len(src))
+	}
+}
@@ -0,0 +1,102 @@
+package grpcutil
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	decompressorQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grpcutil",
+		Name:      "decompressor_queue_depth",
+		Help:      "Number of decompression requests currently waiting for a free worker slot.",
+	})
+	decompressorWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "grpcutil",
+		Name:      "decompressor_wait_duration_seconds",
+		Help:      "Time spent waiting for a decompression worker slot to become available.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	decompressorBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grpcutil",
+		Name:      "decompressor_decompressed_bytes_total",
+		Help:      "Total decompressed bytes produced, by codec.",
+	}, []string{"codec"})
+)
+
+// Decompressor runs decompression on a bounded pool of goroutines instead of
+// the caller's own goroutine, so that a burst of ingest requests can't make
+// every handler goroutine perform a CPU-heavy decode at once. The
+// concurrency cap is independent of, and typically much lower than, the
+// number of concurrent HTTP/gRPC handlers.
+type Decompressor struct {
+	compression CompressionType
+	sem         chan struct{}
+}
+
+// NewDecompressor returns a Decompressor that runs at most concurrency
+// decompressions at a time for the given compression type. A concurrency of
+// 0 or less is treated as 1.
+func NewDecompressor(concurrency int, compression CompressionType) *Decompressor {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Decompressor{
+		compression: compression,
+		sem:         make(chan struct{}, concurrency),
+	}
+}
+
+// Decompress decompresses buf on the worker pool, blocking until a slot is
+// free or ctx is done. It enforces maxSize the same way decompressFromBuffer
+// does for the synchronous path.
+func (d *Decompressor) Decompress(ctx context.Context, buf []byte, maxSize int) ([]byte, error) {
+	decompressorQueueDepth.Inc()
+	waitStart := time.Now()
+	select {
+	case d.sem <- struct{}{}:
+		decompressorQueueDepth.Dec()
+	case <-ctx.Done():
+		decompressorQueueDepth.Dec()
+		return nil, ctx.Err()
+	}
+	decompressorWaitDuration.Observe(time.Since(waitStart).Seconds())
+
+	type result struct {
+		body []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Hold the slot until decoding actually finishes, not until
+		// Decompress returns: if the caller below gives up via ctx.Done(),
+		// this goroutine keeps running and must still count against the
+		// concurrency cap, or a burst of cancellations lets unbounded
+		// decodes pile up concurrently.
+		defer func() { <-d.sem }()
+		body, err := decompressBytes(buf, maxSize, d.compression)
+		done <- result{body, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err == nil {
+			decompressorBytesTotal.WithLabelValues(d.compression.String()).Add(float64(len(r.body)))
+		}
+		return r.body, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// decompressBytes decompresses buf without requiring a *bytes.Buffer or a
+// tracing span, for use by callers like Decompressor that operate on raw
+// byte slices off the request's goroutine.
+func decompressBytes(buf []byte, maxSize int, compression CompressionType) ([]byte, error) {
+	body, _, err := decompressFromBuffer(bytes.NewBuffer(buf), maxSize, compression, nil, nil)
+	return body, err
+}
@@ -0,0 +1,88 @@
+package grpcutil
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowCodec stands in for "gzip" in these tests so Decompressor.Decompress
+// can be driven through a codec whose latency and concurrency we control,
+// without needing a real multi-millisecond compressed payload.
+type slowCodec struct {
+	delay   time.Duration
+	running int32
+	maxSeen int32
+}
+
+func (c *slowCodec) Name() string                           { return "gzip" }
+func (c *slowCodec) Encode(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+func (c *slowCodec) Decode(dst, src []byte, maxSize int) ([]byte, error) {
+	n := atomic.AddInt32(&c.running, 1)
+	for {
+		prev := atomic.LoadInt32(&c.maxSeen)
+		if n <= prev || atomic.CompareAndSwapInt32(&c.maxSeen, prev, n) {
+			break
+		}
+	}
+	time.Sleep(c.delay)
+	atomic.AddInt32(&c.running, -1)
+	return append(dst, src...), nil
+}
+
+func withSlowGzipCodec(t *testing.T, delay time.Duration) *slowCodec {
+	t.Helper()
+	real, _ := codecByName("gzip")
+	slow := &slowCodec{delay: delay}
+	RegisterCodec(slow)
+	t.Cleanup(func() { RegisterCodec(real) })
+	return slow
+}
+
+func TestDecompressorEnforcesConcurrencyCap(t *testing.T) {
+	slow := withSlowGzipCodec(t, 20*time.Millisecond)
+	d := NewDecompressor(2, GZIP)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.Decompress(context.Background(), []byte("payload"), 1024); err != nil {
+				t.Errorf("Decompress: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&slow.maxSeen); max > 2 {
+		t.Errorf("observed %d concurrent decodes, want at most 2", max)
+	}
+}
+
+func TestDecompressorHoldsSlotUntilDecodeFinishes(t *testing.T) {
+	withSlowGzipCodec(t, 100*time.Millisecond)
+	d := NewDecompressor(1, GZIP)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		_, _ = d.Decompress(ctx, []byte("payload"), 1024)
+	}()
+	// Give the first call time to acquire the only slot, then cancel it
+	// before its (slow) decode has a chance to finish.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	start := time.Now()
+	if _, err := d.Decompress(context.Background(), []byte("payload"), 1024); err != nil {
+		t.Fatalf("Decompress: %v", err)
+	}
+	// If the slot were freed as soon as the cancelled call returned, this
+	// second call would acquire it almost immediately. It must instead wait
+	// for the first call's decode goroutine to actually finish.
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("second Decompress returned after %s, want it to block until the first decode finished", elapsed)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
@@ -22,18 +23,58 @@ type CompressionType int
 const (
 	NoCompression CompressionType = iota
 	RawSnappy
+	GZIP
+	Zstd
+	S2
+	// Brotli has no built-in codec; register one via RegisterCodec to use it.
+	Brotli
 )
 
+// String returns the Content-Encoding token associated with c, or "" for
+// NoCompression. It is used both to pick a registered Codec and to set the
+// Content-Encoding header.
+func (c CompressionType) String() string {
+	switch c {
+	case RawSnappy:
+		return "snappy"
+	case GZIP:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case S2:
+		return "s2"
+	case Brotli:
+		return "br"
+	default:
+		return ""
+	}
+}
+
 // ParseProtoReader parses a compressed proto from an io.Reader.
 func ParseProtoReader(ctx context.Context, reader io.Reader, expectedSize, maxSize int, req proto.Message, compression CompressionType) error {
+	return parseProtoReader(ctx, reader, expectedSize, maxSize, req, compression, nil)
+}
+
+// ParseProtoReaderWithBuffers behaves like ParseProtoReader, but draws the
+// read buffer and the decompression destination from buffers instead of
+// allocating them, to cut GC pressure on the ingest hot path. The read
+// buffer goes back to the pool as soon as the body has been decompressed
+// (or copied out), the destination once req has been unmarshaled. No read
+// buffer is drawn when reader already exposes its own *bytes.Buffer.
+func ParseProtoReaderWithBuffers(ctx context.Context, reader io.Reader, expectedSize, maxSize int, req proto.Message, compression CompressionType, buffers *RequestBuffers) error {
+	return parseProtoReader(ctx, reader, expectedSize, maxSize, req, compression, buffers)
+}
+
+func parseProtoReader(ctx context.Context, reader io.Reader, expectedSize, maxSize int, req proto.Message, compression CompressionType, buffers *RequestBuffers) error {
 	sp := opentracing.SpanFromContext(ctx)
 	if sp != nil {
 		sp.LogFields(otlog.String("event", "util.ParseProtoRequest[start reading]"))
 	}
-	body, err := decompressRequest(reader, expectedSize, maxSize, compression, sp)
+	body, release, err := decompressRequest(reader, expectedSize, maxSize, compression, sp, buffers)
 	if err != nil {
 		return err
 	}
+	defer release()
 
 	if sp != nil {
 		sp.LogFields(otlog.String("event", "util.ParseProtoRequest[unmarshal]"), otlog.Int("size", len(body)))
@@ -54,57 +95,72 @@ func ParseProtoReader(ctx context.Context, reader io.Reader, expectedSize, maxSi
 	return nil
 }
 
-func decompressRequest(reader io.Reader, expectedSize, maxSize int, compression CompressionType, sp opentracing.Span) (body []byte, err error) {
+// decompressRequest returns the decompressed body along with a release func
+// that returns the pooled decompression destination, if one was used, back
+// to buffers (a no-op if buffers is nil). A pooled read buffer is already
+// back in the pool by the time decompressRequest returns. The caller must
+// invoke release once body is no longer needed, i.e. after unmarshal.
+func decompressRequest(reader io.Reader, expectedSize, maxSize int, compression CompressionType, sp opentracing.Span, buffers *RequestBuffers) (body []byte, release func(), err error) {
+	release = func() {}
 	defer func() {
 		if err != nil && len(body) > maxSize {
 			err = fmt.Errorf(messageSizeLargerErrFmt, len(body), maxSize)
 		}
 	}()
 	if expectedSize > maxSize {
-		return nil, fmt.Errorf(messageSizeLargerErrFmt, expectedSize, maxSize)
+		return nil, release, fmt.Errorf(messageSizeLargerErrFmt, expectedSize, maxSize)
 	}
-	buffer, ok := tryBufferFromReader(reader)
-	if ok {
-		body, err = decompressFromBuffer(buffer, maxSize, compression, sp)
+	if buf, ok := tryBufferFromReader(reader); ok {
+		body, release, err = decompressFromBuffer(buf, maxSize, compression, sp, buffers)
 		return
 	}
-	body, err = decompressFromReader(reader, expectedSize, maxSize, compression, sp)
+	body, release, err = decompressFromReader(reader, expectedSize, maxSize, compression, sp, buffers)
 	return
 }
 
-func decompressFromReader(reader io.Reader, expectedSize, maxSize int, compression CompressionType, sp opentracing.Span) ([]byte, error) {
-	var (
-		buf  bytes.Buffer
-		body []byte
-		err  error
-	)
+func decompressFromReader(reader io.Reader, expectedSize, maxSize int, compression CompressionType, sp opentracing.Span, buffers *RequestBuffers) ([]byte, func(), error) {
+	var buf *bytes.Buffer
+	if buffers != nil {
+		buf = buffers.Get()
+		defer buffers.Release(buf, nil)
+	} else {
+		buf = &bytes.Buffer{}
+	}
 	if expectedSize > 0 {
 		buf.Grow(expectedSize + bytes.MinRead) // extra space guarantees no reallocation
 	}
 	// Read from LimitReader with limit max+1. So if the underlying
 	// reader is over limit, the result will be bigger than max.
 	reader = io.LimitReader(reader, int64(maxSize)+1)
+	var err error
 	switch compression {
 	case NoCompression:
+		// buf may come from the pool and then be returned above, so
+		// copy out its contents rather than handing back a slice into
+		// a buffer we're about to recycle.
 		_, err = buf.ReadFrom(reader)
-		body = buf.Bytes()
-	case RawSnappy:
+		if err != nil {
+			return nil, func() {}, err
+		}
+		body := append([]byte(nil), buf.Bytes()...)
+		return body, func() {}, nil
+	default:
 		_, err = buf.ReadFrom(reader)
 		if err != nil {
-			return nil, err
+			return nil, func() {}, err
 		}
-		body, err = decompressFromBuffer(&buf, maxSize, RawSnappy, sp)
+		return decompressFromBuffer(buf, maxSize, compression, sp, buffers)
 	}
-	return body, err
 }
 
-func decompressFromBuffer(buffer *bytes.Buffer, maxSize int, compression CompressionType, sp opentracing.Span) ([]byte, error) {
+func decompressFromBuffer(buffer *bytes.Buffer, maxSize int, compression CompressionType, sp opentracing.Span, buffers *RequestBuffers) ([]byte, func(), error) {
+	noop := func() {}
 	if len(buffer.Bytes()) > maxSize {
-		return nil, fmt.Errorf(messageSizeLargerErrFmt, len(buffer.Bytes()), maxSize)
+		return nil, noop, fmt.Errorf(messageSizeLargerErrFmt, len(buffer.Bytes()), maxSize)
 	}
 	switch compression {
 	case NoCompression:
-		return buffer.Bytes(), nil
+		return buffer.Bytes(), noop, nil
 	case RawSnappy:
 		if sp != nil {
 			sp.LogFields(otlog.String("event", "util.ParseProtoRequest[decompress]"),
@@ -112,18 +168,53 @@ func decompressFromBuffer(buffer *bytes.Buffer, maxSize int, compression Compres
 		}
 		size, err := snappy.DecodedLen(buffer.Bytes())
 		if err != nil {
-			return nil, err
+			return nil, noop, err
 		}
 		if size > maxSize {
-			return nil, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
+			return nil, noop, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
 		}
-		body, err := snappy.Decode(nil, buffer.Bytes())
+		dst, release := pooledDst(buffers, size)
+		body, err := snappy.Decode(dst, buffer.Bytes())
 		if err != nil {
-			return nil, err
+			return nil, noop, err
+		}
+		return body, release, nil
+	default:
+		codec, ok := codecByName(compression.String())
+		if !ok {
+			return nil, noop, fmt.Errorf("grpcutil: no codec registered for compression %q", compression.String())
 		}
-		return body, nil
+		if sp != nil {
+			sp.LogFields(otlog.String("event", "util.ParseProtoRequest[decompress]"),
+				otlog.Int("size", len(buffer.Bytes())))
+		}
+		hint := 0
+		if sized, ok := codec.(SizedCodec); ok {
+			if size, err := sized.DecompressedSize(buffer.Bytes(), maxSize); err == nil {
+				if size > maxSize {
+					return nil, noop, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
+				}
+				hint = size
+			}
+		}
+		dst, release := pooledDst(buffers, hint)
+		body, err := codec.Decode(dst, buffer.Bytes(), maxSize)
+		if err != nil {
+			return nil, noop, err
+		}
+		return body, release, nil
 	}
-	return nil, nil
+}
+
+// pooledDst returns a destination slice of the given target length from
+// buffers (or nil if buffers is nil or the length is unknown), along with
+// the release func that returns it once the caller is done.
+func pooledDst(buffers *RequestBuffers, size int) ([]byte, func()) {
+	if buffers == nil {
+		return nil, func() {}
+	}
+	dst := buffers.getDst(size)
+	return dst, func() { buffers.Release(nil, dst) }
 }
 
 // tryBufferFromReader attempts to cast the reader to a `*bytes.Buffer` this is possible when using httpgrpc.
@@ -137,23 +228,116 @@ func tryBufferFromReader(reader io.Reader) (*bytes.Buffer, bool) {
 	return nil, false
 }
 
-// SerializeProtoResponse serializes a protobuf response into an HTTP response.
+// SerializeProtoResponse serializes a protobuf response into an HTTP
+// response, compressed with compression.
 func SerializeProtoResponse(w http.ResponseWriter, resp proto.Message, compression CompressionType) error {
+	return writeProtoResponse(w, resp, compression)
+}
+
+// SerializeProtoResponseWithNegotiation serializes resp into an HTTP
+// response, choosing the compression from r's Accept-Encoding header and
+// the set of registered codecs (plus snappy). It sets Content-Encoding and
+// Vary: Accept-Encoding accordingly, so handlers don't need to hardcode a
+// CompressionType.
+func SerializeProtoResponseWithNegotiation(w http.ResponseWriter, r *http.Request, resp proto.Message) error {
+	w.Header().Set("Vary", "Accept-Encoding")
+	compression := negotiateCompression(r.Header.Get("Accept-Encoding"))
+	return writeProtoResponse(w, resp, compression)
+}
+
+// negotiateCompression picks the first codec in acceptEncoding (a
+// comma-separated Accept-Encoding header value) that grpcutil can produce,
+// ignoring q-values. It returns NoCompression if none match.
+func negotiateCompression(acceptEncoding string) CompressionType {
+	for _, tok := range strings.Split(acceptEncoding, ",") {
+		name := strings.TrimSpace(tok)
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			name = strings.TrimSpace(name[:i])
+		}
+		switch name {
+		case "snappy":
+			return RawSnappy
+		case "gzip":
+			return GZIP
+		case "zstd":
+			return Zstd
+		case "s2":
+			return S2
+		case "br":
+			if _, ok := codecByName("br"); ok {
+				return Brotli
+			}
+		}
+	}
+	return NoCompression
+}
+
+// writeProtoResponse marshals resp and writes it to w, streaming it through
+// a compressing io.Writer rather than allocating the full compressed
+// payload up front when the codec supports it. Content-Length is left
+// unset when compressing: the compressed size isn't known ahead of the
+// write, and advertising a stale length is exactly the class of bug
+// easegress hit compressing responses whose length changes after the fact.
+func writeProtoResponse(w http.ResponseWriter, resp proto.Message, compression CompressionType) error {
 	data, err := proto.Marshal(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return fmt.Errorf("error marshaling proto response: %v", err)
 	}
 
+	if enc := compression.String(); enc != "" {
+		w.Header().Set("Content-Encoding", enc)
+	}
+
+	var cw io.WriteCloser
 	switch compression {
 	case NoCompression:
+		if _, err := w.Write(data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return fmt.Errorf("error sending proto response: %v", err)
+		}
+		return nil
 	case RawSnappy:
-		data = snappy.Encode(nil, data)
+		// snappy.Encode produces the raw block format, the same one
+		// decompressFromBuffer's RawSnappy arm decodes with snappy.Decode.
+		// snappy.NewBufferedWriter writes the distinct framed streaming
+		// format instead, which that decoder can't read.
+		encoded := snappy.Encode(nil, data)
+		if _, err := w.Write(encoded); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return fmt.Errorf("error sending proto response: %v", err)
+		}
+		return nil
+	default:
+		codec, ok := codecByName(compression.String())
+		if !ok {
+			err := fmt.Errorf("grpcutil: no codec registered for compression %q", compression.String())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return err
+		}
+		sc, ok := codec.(StreamingCodec)
+		if !ok {
+			encoded, err := codec.Encode(nil, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return fmt.Errorf("error compressing proto response: %v", err)
+			}
+			if _, err := w.Write(encoded); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return fmt.Errorf("error sending proto response: %v", err)
+			}
+			return nil
+		}
+		cw = sc.NewWriter(w)
 	}
 
-	if _, err := w.Write(data); err != nil {
+	if _, err := cw.Write(data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return fmt.Errorf("error sending proto response: %v", err)
+	}
+	if err := cw.Close(); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return fmt.Errorf("error sending proto response: %v", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
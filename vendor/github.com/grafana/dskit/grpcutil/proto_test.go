@@ -0,0 +1,79 @@
+package grpcutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestNegotiateCompression(t *testing.T) {
+	for _, tc := range []struct {
+		acceptEncoding string
+		want           CompressionType
+	}{
+		{"", NoCompression},
+		{"identity", NoCompression},
+		{"gzip", GZIP},
+		{"br;q=1.0, gzip;q=0.8", Brotli}, // "br" only wins if a brotli codec is registered
+		{"snappy, gzip", RawSnappy},
+		{"zstd", Zstd},
+		{"s2", S2},
+	} {
+		got := negotiateCompression(tc.acceptEncoding)
+		if tc.want == Brotli {
+			// No brotli codec is registered by default, so it must fall
+			// through to the next supported token.
+			if got != GZIP {
+				t.Errorf("negotiateCompression(%q) = %v, want %v (brotli unavailable, falls back to gzip)", tc.acceptEncoding, got, GZIP)
+			}
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("negotiateCompression(%q) = %v, want %v", tc.acceptEncoding, got, tc.want)
+		}
+	}
+}
+
+// TestSerializeProtoResponseRawSnappyMatchesRequestDecode is a regression
+// test for a wire-compatibility bug: SerializeProtoResponse must produce
+// the same raw block snappy format that ParseProtoReader (through
+// decompressFromBuffer) and ParseProtoStream (through decompressFrame)
+// decode with snappy.Decode, not the distinct framed streaming format.
+func TestSerializeProtoResponseRawSnappyMatchesRequestDecode(t *testing.T) {
+	want := []byte("hello from the response path")
+	rec := httptest.NewRecorder()
+
+	if err := SerializeProtoResponse(rec, &testMessage{Data: want}, RawSnappy); err != nil {
+		t.Fatalf("SerializeProtoResponse: %v", err)
+	}
+
+	got, err := snappy.Decode(nil, rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("snappy.Decode (block format) on the response body: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("decoded response = %q, want %q", got, want)
+	}
+}
+
+func TestSerializeProtoResponseWithNegotiationSetsHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := SerializeProtoResponseWithNegotiation(rec, req, &testMessage{Data: []byte("payload")}); err != nil {
+		t.Fatalf("SerializeProtoResponseWithNegotiation: %v", err)
+	}
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding = %q, want %q", got, "gzip")
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want %q", got, "Accept-Encoding")
+	}
+	if got := rec.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q, want unset while streaming a compressed response", got)
+	}
+}
@@ -0,0 +1,95 @@
+package grpcutil
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+// frameHeaderLen is the length of the frame header ParseProtoStream reads
+// ahead of each message: a 1-byte compression flag followed by a 4-byte
+// big-endian message length, matching gRPC's own wire framing.
+const frameHeaderLen = 5
+
+// ParseProtoStream reads a single length-prefixed, optionally compressed
+// proto message from reader and unmarshals it into req. The frame is a
+// 1-byte compression flag (0 for NoCompression, 1 for compressed) followed
+// by a 4-byte big-endian length, mirroring gRPC's wire format.
+//
+// Unlike ParseProtoReader, which reads up to maxSize+1 bytes before
+// discovering an oversize request, ParseProtoStream inspects the declared
+// length first and rejects the message before allocating whenever it
+// exceeds maxSize. For a compressed frame whose codec implements
+// SizedCodec, the decompressed size is also checked against maxSize before
+// the frame is decompressed, closing the class of DoS vectors where a
+// small compressed body claims a huge decompressed size.
+//
+// compression selects the codec used to interpret a compressed frame; it is
+// ignored for uncompressed frames. ParseProtoStream may be called
+// repeatedly on the same reader to consume multiple framed messages.
+func ParseProtoStream(ctx context.Context, reader io.Reader, maxSize int, req proto.Message, compression CompressionType) error {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return err
+	}
+	compressed := header[0] != 0
+	length := int(binary.BigEndian.Uint32(header[1:]))
+	if length > maxSize {
+		return fmt.Errorf(messageSizeLargerErrFmt, length, maxSize)
+	}
+
+	frame := make([]byte, length)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return err
+	}
+
+	body := frame
+	if compressed {
+		decoded, err := decompressFrame(frame, maxSize, compression)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+	if len(body) > maxSize {
+		return fmt.Errorf(messageSizeLargerErrFmt, len(body), maxSize)
+	}
+
+	req.Reset()
+	if u, ok := req.(proto.Unmarshaler); ok {
+		return u.Unmarshal(body)
+	}
+	return proto.NewBuffer(body).Unmarshal(req)
+}
+
+// decompressFrame decompresses a single compressed frame. RawSnappy is
+// handled directly, the same way decompressFromBuffer does, since it is
+// handled as a special case rather than through the codec registry; every
+// other CompressionType is resolved via codecByName.
+func decompressFrame(frame []byte, maxSize int, compression CompressionType) ([]byte, error) {
+	if compression == RawSnappy {
+		size, err := snappy.DecodedLen(frame)
+		if err != nil {
+			return nil, err
+		}
+		if size > maxSize {
+			return nil, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
+		}
+		return snappy.Decode(nil, frame)
+	}
+
+	codec, ok := codecByName(compression.String())
+	if !ok {
+		return nil, fmt.Errorf("grpcutil: no codec registered for compression %q", compression.String())
+	}
+	if sized, ok := codec.(SizedCodec); ok {
+		if size, err := sized.DecompressedSize(frame, maxSize); err == nil && size > maxSize {
+			return nil, fmt.Errorf(messageSizeLargerErrFmt, size, maxSize)
+		}
+	}
+	return codec.Decode(nil, frame, maxSize)
+}
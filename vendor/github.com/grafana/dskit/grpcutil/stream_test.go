@@ -0,0 +1,104 @@
+package grpcutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// testMessage is a minimal gogo-proto-compatible message used to exercise
+// ParseProtoReader/ParseProtoStream/SerializeProtoResponse without pulling
+// in generated proto code: its wire format is just its raw bytes.
+type testMessage struct {
+	Data []byte
+}
+
+func (m *testMessage) Reset()         { m.Data = nil }
+func (m *testMessage) String() string { return string(m.Data) }
+func (*testMessage) ProtoMessage()    {}
+
+func (m *testMessage) Marshal() ([]byte, error) {
+	return append([]byte(nil), m.Data...), nil
+}
+
+func (m *testMessage) Unmarshal(data []byte) error {
+	m.Data = append([]byte(nil), data...)
+	return nil
+}
+
+func frame(compressed bool, body []byte) []byte {
+	header := make([]byte, frameHeaderLen)
+	if compressed {
+		header[0] = 1
+	}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(body)))
+	return append(header, body...)
+}
+
+func TestParseProtoStreamUncompressed(t *testing.T) {
+	want := []byte("hello, loki")
+	reader := bytes.NewReader(frame(false, want))
+
+	var req testMessage
+	if err := ParseProtoStream(context.Background(), reader, 1024, &req, NoCompression); err != nil {
+		t.Fatalf("ParseProtoStream: %v", err)
+	}
+	if string(req.Data) != string(want) {
+		t.Errorf("req.Data = %q, want %q", req.Data, want)
+	}
+}
+
+func TestParseProtoStreamRawSnappy(t *testing.T) {
+	want := []byte("hello, loki, compressed")
+	reader := bytes.NewReader(frame(true, snappy.Encode(nil, want)))
+
+	var req testMessage
+	if err := ParseProtoStream(context.Background(), reader, 1024, &req, RawSnappy); err != nil {
+		t.Fatalf("ParseProtoStream: %v", err)
+	}
+	if string(req.Data) != string(want) {
+		t.Errorf("req.Data = %q, want %q", req.Data, want)
+	}
+}
+
+func TestParseProtoStreamRejectsOversizeLengthBeforeReading(t *testing.T) {
+	header := make([]byte, frameHeaderLen)
+	binary.BigEndian.PutUint32(header[1:], 1<<20) // declared length, no body follows
+
+	var req testMessage
+	err := ParseProtoStream(context.Background(), bytes.NewReader(header), 16, &req, NoCompression)
+	if err == nil {
+		t.Fatal("expected an error for a declared length over maxSize")
+	}
+	// A read of the missing body would fail with a short-read error instead;
+	// the size error shows the length was rejected before any read.
+	if want := fmt.Sprintf(messageSizeLargerErrFmt, 1<<20, 16); err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
+
+func TestParseProtoStreamTruncatedFrame(t *testing.T) {
+	full := frame(false, []byte("not fully delivered"))
+	reader := bytes.NewReader(full[:len(full)-3])
+
+	var req testMessage
+	if err := ParseProtoStream(context.Background(), reader, 1024, &req, NoCompression); err == nil {
+		t.Fatal("expected an error for a truncated frame")
+	}
+}
+
+func TestParseProtoStreamZeroLengthFrame(t *testing.T) {
+	reader := bytes.NewReader(frame(false, nil))
+
+	var req testMessage
+	if err := ParseProtoStream(context.Background(), reader, 1024, &req, NoCompression); err != nil {
+		t.Fatalf("ParseProtoStream: %v", err)
+	}
+	if len(req.Data) != 0 {
+		t.Errorf("req.Data = %q, want empty", req.Data)
+	}
+}